@@ -0,0 +1,54 @@
+// Command specialops is a small CLI wrapper around the specialops package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/solidifylabs/specialops"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "disassemble":
+		disassemble(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: specialops disassemble <hex bytecode>")
+}
+
+func disassemble(args []string) {
+	fs := flag.NewFlagSet("disassemble", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	bytecode, err := hexutil.Decode(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decoding bytecode: %v\n", err)
+		os.Exit(1)
+	}
+
+	code, err := specialops.Disassemble(bytecode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "disassembling: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(code.GoString())
+}
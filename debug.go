@@ -0,0 +1,223 @@
+package specialops
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/holiman/uint256"
+)
+
+// Breakpoint identifies where Debug should pause and hand control back to
+// the user: either the label of a declared JUMPDEST, or the index of a
+// top-level Code element. Construct one with BreakAtLabel or BreakAtIndex.
+type Breakpoint struct {
+	Label     string
+	CodeIndex int // used when Label == ""
+}
+
+// BreakAtLabel returns a Breakpoint on the JUMPDEST declared with label.
+func BreakAtLabel(label string) Breakpoint { return Breakpoint{Label: label} }
+
+// BreakAtIndex returns a Breakpoint on the top-level Code element at i.
+func BreakAtIndex(i int) Breakpoint { return Breakpoint{CodeIndex: i} }
+
+// DebugOptions configures an interactive Debug session. In and Out default
+// to os.Stdin and os.Stdout, which is what makes Debug usable as a terminal
+// debugger; tests instead script a session by passing their own Reader and
+// capturing Writer.
+type DebugOptions struct {
+	In  io.Reader
+	Out io.Writer
+	// Breakpoints does not suppress Debug's pause at the very first
+	// instruction (see Debug); a session that should run straight to its
+	// first breakpoint needs a leading "continue" command.
+	Breakpoints []Breakpoint
+}
+
+// Debug compiles code and runs it against callData step by step through
+// go-ethereum's interpreter, printing the Code element responsible for the
+// currently executing instruction alongside the EVM stack, memory, and
+// remaining gas, and pausing for a command whenever a breakpoint is hit.
+// The session always pauses before the first instruction too, regardless
+// of opts.Breakpoints, the same as running a terminal debugger with a
+// breakpoint already set: it still stops at entry first.
+//
+// Commands, read one per line from opts.In:
+//
+//	s, step     execute a single instruction, entering any Fn(...) group
+//	o, over     run until the innermost Fn(...) group containing the
+//	            current instruction has finished, without pausing inside it
+//	c, continue run to the next breakpoint, or to completion
+//	q, quit     stop pausing; the program still runs to completion, since
+//	            a tracer hook has no way to abort an in-flight EVM call
+//
+// A blank line repeats the previous command, as in most terminal debuggers.
+func Debug(code Code, callData []byte, opts DebugOptions) ([]byte, error) {
+	in, out := opts.In, opts.Out
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+
+	compiled, srcMap, err := code.CompileWithSourceMap()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &debugger{srcMap: srcMap, in: bufio.NewScanner(in), out: out}
+	for _, bp := range opts.Breakpoints {
+		pc, ok := srcMap.pcForBreakpoint(bp)
+		if !ok {
+			return nil, fmt.Errorf("specialops: breakpoint %+v matches nothing in code", bp)
+		}
+		d.breakpoints = append(d.breakpoints, pc)
+	}
+
+	ret, _, err := runtime.Execute(compiled, callData, &runtime.Config{
+		EVMConfig: vm.Config{Tracer: &tracing.Hooks{OnOpcode: d.onOpcode}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d.quit {
+		return ret, fmt.Errorf("specialops: debug session quit before completion")
+	}
+	return ret, nil
+}
+
+// pcForBreakpoint resolves bp to the PC it should pause at: a label
+// breakpoint matches the JUMPDEST span declaring it; a code-index
+// breakpoint matches the top-level span (not inside any Sub) whose Path is
+// that single index.
+func (m SourceMap) pcForBreakpoint(bp Breakpoint) (int, bool) {
+	for _, s := range m {
+		if bp.Label != "" {
+			if s.Label == bp.Label {
+				return s.PC, true
+			}
+			continue
+		}
+		if s.Sub == "" && len(s.Path) == 1 && s.Path[0] == bp.CodeIndex {
+			return s.PC, true
+		}
+	}
+	return 0, false
+}
+
+// debugMode is what the debugger does with control between prompts: pause
+// at the very next instruction, run until stepOverEnd, or run to the next
+// breakpoint.
+type debugMode int
+
+const (
+	modePause debugMode = iota
+	modeStepOver
+	modeContinue
+)
+
+// debugger is the tracing.Hooks.OnOpcode callback's state across calls. Its
+// methods are invoked synchronously from inside the EVM interpreter, once
+// per instruction, so blocking on d.in to read the next command is exactly
+// how a single-stepping terminal debugger should behave: execution stands
+// still until the user types something.
+type debugger struct {
+	srcMap      SourceMap
+	in          *bufio.Scanner
+	out         io.Writer
+	breakpoints []int
+
+	mode        debugMode
+	stepOverEnd int
+	lastCmd     string
+	quit        bool
+}
+
+func (d *debugger) onOpcode(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+	if d.quit {
+		return
+	}
+	at := int(pc)
+
+	pause := d.mode == modePause
+	if d.mode == modeStepOver && at >= d.stepOverEnd {
+		pause = true
+	}
+	for _, bp := range d.breakpoints {
+		if bp == at {
+			pause = true
+		}
+	}
+	if !pause {
+		return
+	}
+
+	span, _ := d.srcMap.spanAt(at)
+	fmt.Fprintf(d.out, "pc=%-4d %-24s gas=%d\n", at, describeSpan(span), gas)
+	fmt.Fprintf(d.out, "  stack:  %s\n", stackString(scope.StackData()))
+	fmt.Fprintf(d.out, "  memory: %#x\n", scope.MemoryData())
+
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			d.quit = true
+			return
+		}
+		cmd := strings.TrimSpace(d.in.Text())
+		if cmd == "" {
+			cmd = d.lastCmd
+		}
+		d.lastCmd = cmd
+
+		switch cmd {
+		case "s", "step":
+			d.mode = modePause
+			return
+		case "o", "over":
+			// Run to the end of the innermost Fn(...) span covering this
+			// instruction, or of its own span if no Fn(...) covers it.
+			d.mode = modeStepOver
+			d.stepOverEnd = span.EndPC
+			width := -1
+			for _, s := range d.srcMap {
+				if at >= s.PC && at < s.EndPC && strings.HasPrefix(s.Description, "Fn(") && (width < 0 || s.EndPC-s.PC < width) {
+					d.stepOverEnd, width = s.EndPC, s.EndPC-s.PC
+				}
+			}
+			return
+		case "c", "continue":
+			d.mode = modeContinue
+			return
+		case "q", "quit":
+			d.quit = true
+			return
+		default:
+			fmt.Fprintf(d.out, "unknown command %q; try step/over/continue/quit\n", cmd)
+		}
+	}
+}
+
+func describeSpan(s SourceSpan) string {
+	if s.Description == "" {
+		return "<no source mapping>"
+	}
+	if s.Sub != "" {
+		return fmt.Sprintf("Sub(%q): %s", s.Sub, s.Description)
+	}
+	return s.Description
+}
+
+func stackString(stack []uint256.Int) string {
+	parts := make([]string, len(stack))
+	for i, v := range stack {
+		parts[i] = v.Hex()
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,89 @@
+package specialops
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestDebugStepsOverAndContinues scripts a debug session — a step, an over,
+// then a continue — against a small fixture, and checks both that the
+// program still runs to its correct result and that each pause printed the
+// Code element responsible for the current instruction.
+func TestDebugStepsOverAndContinues(t *testing.T) {
+	code := Code{
+		Fn(MSTORE, PUSH0, Fn(ADD, PUSH(1), PUSH(2))), // <> {1+2}
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+	}
+
+	var out bytes.Buffer
+	in := strings.NewReader("step\nover\ncontinue\n")
+	ret, err := Debug(code, nil, DebugOptions{In: in, Out: &out})
+	if err != nil {
+		t.Fatalf("Debug() error %v", err)
+	}
+	if !bytes.Equal(ret, word(3)) {
+		t.Errorf("Debug() returned %#x, want %#x", ret, word(3))
+	}
+
+	got := out.String()
+	// "over" at PUSH(0x01) runs the rest of Fn(ADD, ...) and pauses at PUSH0.
+	for _, want := range []string{"PUSH(0x02)", "PUSH(0x01)", "PUSH0", "pc="} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Debug() output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+// TestDebugBreakpointStopsAtLabel proves BreakAtLabel resolves to the right
+// PC by running straight to completion save for the entry pause and a single
+// pause at the breakpoint.
+func TestDebugBreakpointStopsAtLabel(t *testing.T) {
+	code := Code{
+		Fn(JUMP, PUSHJUMPDEST("ret")),
+		JUMPDEST("ret"),
+		Fn(MSTORE, PUSH0, PUSH(7)),
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+	}
+
+	var out bytes.Buffer
+	// The first "continue" escapes Debug's break-at-entry pause; the second
+	// resumes past the breakpoint it then hits at JUMPDEST("ret").
+	in := strings.NewReader("continue\ncontinue\n")
+	ret, err := Debug(code, nil, DebugOptions{
+		In:          in,
+		Out:         &out,
+		Breakpoints: []Breakpoint{BreakAtLabel("ret")},
+	})
+	if err != nil {
+		t.Fatalf("Debug() error %v", err)
+	}
+	if !bytes.Equal(ret, word(7)) {
+		t.Errorf("Debug() returned %#x, want %#x", ret, word(7))
+	}
+	if !strings.Contains(out.String(), `JUMPDEST("ret")`) {
+		t.Errorf("Debug() output missing the JUMPDEST(\"ret\") pause:\n%s", out.String())
+	}
+}
+
+// TestDebugQuitRunsToCompletion proves that "quit" stops the debugger from
+// pausing further but, since a live-tracing hook can't abort an in-flight
+// EVM call, still lets the program run to completion — and that Debug
+// surfaces that via an error rather than pretending the session finished
+// normally.
+func TestDebugQuitRunsToCompletion(t *testing.T) {
+	code := Code{
+		Fn(MSTORE, PUSH0, Fn(ADD, PUSH(1), PUSH(2))),
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+	}
+
+	var out bytes.Buffer
+	in := strings.NewReader("quit\n")
+	ret, err := Debug(code, nil, DebugOptions{In: in, Out: &out})
+	if err == nil {
+		t.Fatal("Debug() with a quit command should report an error")
+	}
+	if !bytes.Equal(ret, word(3)) {
+		t.Errorf("Debug() returned %#x after quit, want %#x (the EVM still ran to completion)", ret, word(3))
+	}
+}
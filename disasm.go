@@ -0,0 +1,195 @@
+package specialops
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Disassemble reconstructs a Code slice from raw EVM bytecode, roughly
+// inverting Code.Compile(). It's meant for round-tripping through bytecode
+// a user doesn't have the DSL source for, e.g. pasting the result into a
+// test like TestRunCompiled.
+//
+// The reconstruction isn't guaranteed to be the Code that produced the
+// input: PUSHn immediates are folded into PUSH([]byte{...}), and a
+// small peephole groups adjacent operations into Fn(...) where their
+// static stack in/out counts balance, but arbitrary nesting isn't
+// recovered.
+func Disassemble(bytecode []byte) (Code, error) {
+	dests := jumpdestAnalysis(bytecode)
+
+	labels := make(map[int]string, len(dests))
+	n := 0
+	for pc := range dests {
+		n++
+		labels[pc] = fmt.Sprintf("lbl%d", n)
+	}
+
+	var code Code
+	for pc := 0; pc < len(bytecode); {
+		op := vm.OpCode(bytecode[pc])
+
+		if lbl, ok := labels[pc]; ok && op == vm.JUMPDEST {
+			code = append(code, JUMPDEST(lbl))
+			pc++
+			continue
+		}
+
+		if op >= vm.PUSH1 && op <= vm.PUSH32 {
+			n := int(op - vm.PUSH1 + 1)
+			if pc+1+n > len(bytecode) {
+				return nil, fmt.Errorf("specialops: truncated PUSH%d at pc=%d", n, pc)
+			}
+			data := bytecode[pc+1 : pc+1+n]
+
+			// A PUSH2 of a known jump destination round-trips as
+			// PUSHJUMPDEST(label) instead of a raw constant.
+			if n == 2 {
+				dest := int(data[0])<<8 | int(data[1])
+				if lbl, ok := labels[dest]; ok {
+					code = append(code, PUSHJUMPDEST(lbl))
+					pc += 1 + n
+					continue
+				}
+			}
+
+			cp := make([]byte, n)
+			copy(cp, data)
+			code = append(code, PUSH(cp))
+			pc += 1 + n
+			continue
+		}
+
+		if op == vm.PUSH0 {
+			code = append(code, PUSH0)
+			pc++
+			continue
+		}
+
+		code = append(code, opCode(op))
+		pc++
+	}
+
+	return foldFunctions(code), nil
+}
+
+// jumpdestAnalysis returns the set of byte offsets that are valid jump
+// destinations, mirroring go-ethereum's analysis.go: it walks the bytecode
+// once, skipping over PUSHn immediates so that immediate bytes that happen
+// to equal the JUMPDEST opcode aren't mistaken for one.
+func jumpdestAnalysis(bytecode []byte) map[int]bool {
+	dests := map[int]bool{}
+	for pc := 0; pc < len(bytecode); {
+		op := vm.OpCode(bytecode[pc])
+		if op == vm.JUMPDEST {
+			dests[pc] = true
+			pc++
+			continue
+		}
+		if op >= vm.PUSH1 && op <= vm.PUSH32 {
+			pc += 1 + int(op-vm.PUSH1+1)
+			continue
+		}
+		pc++
+	}
+	return dests
+}
+
+// foldFunctions is a simple peephole that groups an operation with the
+// operations immediately preceding it into a Fn(...) tree, whenever the
+// preceding elements' static stack effects exactly supply the operation's
+// pop count with one value each. It's intentionally conservative: anything
+// it can't prove balances is left as flat, bare elements.
+func foldFunctions(code Code) Code {
+	var out Code
+	for _, el := range code {
+		op, ok := el.(opCode)
+		if !ok {
+			out = append(out, el)
+			continue
+		}
+		pop, _, known := stackEffect(op)
+		if !known || pop == 0 || len(out) < pop {
+			out = append(out, el)
+			continue
+		}
+
+		args := make([]Element, pop)
+		fold := true
+		for i := 0; i < pop; i++ {
+			cand := out[len(out)-1-i]
+			if !producesExactlyOne(cand) {
+				fold = false
+				break
+			}
+			args[i] = cand
+		}
+		if !fold {
+			out = append(out, el)
+			continue
+		}
+		out = out[:len(out)-pop]
+		// args were collected nearest-first; Fn expects declared order to
+		// match pop order, i.e. nearest-pushed argument declared first.
+		out = append(out, Fn(op, args...))
+	}
+	return out
+}
+
+// GoString renders code as gofmt-able Go source, e.g. for pasting the
+// output of Disassemble into a test.
+func (code Code) GoString() string {
+	var b strings.Builder
+	b.WriteString("Code{\n")
+	for _, el := range code {
+		fmt.Fprintf(&b, "\t%s,\n", elementGoString(el))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func elementGoString(el Element) string {
+	switch v := el.(type) {
+	case opCode:
+		return vm.OpCode(v).String()
+	case pushBytesElement:
+		return fmt.Sprintf("PUSH([]byte{%s})", byteList(v.data))
+	case pushJumpdestElement:
+		return fmt.Sprintf("PUSHJUMPDEST(%q)", v.label)
+	case jumpdestElement:
+		return fmt.Sprintf("JUMPDEST(%q)", v.label)
+	case fnElement:
+		args := make([]string, len(v.args))
+		for i, a := range v.args {
+			args[i] = elementGoString(a)
+		}
+		return fmt.Sprintf("Fn(%s, %s)", vm.OpCode(v.op).String(), strings.Join(args, ", "))
+	default:
+		return fmt.Sprintf("%#v", el)
+	}
+}
+
+func byteList(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("0x%02x", b)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func producesExactlyOne(el Element) bool {
+	switch v := el.(type) {
+	case opCode:
+		_, push, ok := stackEffect(v)
+		return ok && push == 1
+	case fnElement:
+		_, push, ok := stackEffect(v.op)
+		return ok && push == 1
+	case pushBytesElement, pushJumpdestElement:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,47 @@
+package specialops
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestDisassembleRoundTrip mirrors the hashOrEcho fixture from
+// TestRunCompiled (jumps both forwards and backwards via labels) and checks
+// that Disassemble(Compile()) recompiles to the exact same bytecode.
+func TestDisassembleRoundTrip(t *testing.T) {
+	hashOrEcho := Code{
+		Fn(SUB, CALLDATASIZE, PUSH(1)),
+		Fn(CALLDATACOPY, PUSH0, PUSH(1), DUP1),
+
+		Fn(SHR, PUSH(248), Fn(CALLDATALOAD, PUSH0)),
+		Fn(JUMPI, PUSHJUMPDEST("hash")),
+
+		JUMPDEST("return"),
+		Fn(RETURN, PUSH0),
+
+		JUMPDEST("hash"),
+		Fn(MSTORE, PUSH0, Fn(KECCAK256, PUSH0)),
+		PUSH(0x20),
+		Fn(JUMP, PUSH("return")),
+	}
+
+	compiled, err := hashOrEcho.Compile()
+	if err != nil {
+		t.Fatalf("Compile() error %v", err)
+	}
+
+	disassembled, err := Disassemble(compiled)
+	if err != nil {
+		t.Fatalf("Disassemble() error %v", err)
+	}
+	t.Logf("Disassembled:\n%s", disassembled.GoString())
+
+	recompiled, err := disassembled.Compile()
+	if err != nil {
+		t.Fatalf("Compile() of disassembled code error %v", err)
+	}
+
+	if !bytes.Equal(compiled, recompiled) {
+		t.Errorf("round trip mismatch:\noriginal:  %#x\nrecompiled: %#x", compiled, recompiled)
+	}
+}
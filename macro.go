@@ -0,0 +1,209 @@
+package specialops
+
+import "fmt"
+
+// ParamKind distinguishes how a Macro formal parameter is resolved at each
+// Call site.
+type ParamKind int
+
+const (
+	// StackParam is pushed onto the stack by Call, in declared order,
+	// before the macro body runs. The body references it via the
+	// corresponding Arg, which DUPs the value at whatever depth the body
+	// has reached by the time it's used — it stays correct no matter how
+	// much the body grows the stack between references.
+	StackParam ParamKind = iota
+	// ConstParam is a compile-time Go value, substituted via PUSH wherever
+	// the body references the corresponding Arg. Nothing is pushed onto
+	// the stack on its account.
+	ConstParam
+)
+
+// Param declares one formal parameter of a Macro.
+type Param struct {
+	Name string
+	Kind ParamKind
+}
+
+// Arg is a Macro parameter reference: Call resolves each of the macro's
+// Params into an Arg — a DUP for a StackParam, a PUSH/PUSHJUMPDEST for a
+// ConstParam — and passes the results to the macro's body in place of the
+// caller's raw arguments. A body uses args the same way it would use any
+// other Element, e.g. Fn(ADD, args[0], args[1]).
+type Arg interface {
+	Element
+}
+
+// stackArgRef resolves a StackParam: slotDepth is the compiler's stack
+// depth immediately after Call pushed this argument, so later references
+// can recompute how many items now sit above it and DUP accordingly.
+type stackArgRef struct {
+	name      string
+	slotDepth int
+}
+
+func (a stackArgRef) appendTo(c *compiler) error {
+	if c.depth == nil {
+		return fmt.Errorf("specialops: macro arg %q referenced at an unknown stack depth", a.name)
+	}
+	n := *c.depth - a.slotDepth + 1
+	if n < 1 || n > 16 {
+		return fmt.Errorf("specialops: macro arg %q is %d deep, out of DUP range", a.name, n)
+	}
+	return (DUP1 + opCode(n-1)).appendTo(c)
+}
+
+// constArgRef resolves a ConstParam: it's just the PUSH/PUSHJUMPDEST Call
+// built from the caller's value, replayed wherever the body references it.
+type constArgRef struct{ element Element }
+
+func (a constArgRef) appendTo(c *compiler) error { return a.element.appendTo(c) }
+
+// depthOf returns the compiler depth recorded when a StackParam Arg was
+// pushed, letting a macro body recover a known depth (via SetStackDepth)
+// after a branch the linear tracker can't see through on its own — e.g. a
+// revert-on-failure check, whose JUMPDEST is only reachable by the jump, not
+// by falling through the REVERT before it. Misusing it on a ConstParam Arg
+// panics; that's only reachable from within this package.
+func depthOf(a Arg) int { return a.(stackArgRef).slotDepth }
+
+// Macro declares a reusable, parameterized Code fragment. Unlike Sub, a
+// Macro is expanded inline at every Call site rather than hoisted and
+// jumped into: each invocation gets its own copy of the body, so there's no
+// control-flow or cross-call stack-depth bookkeeping at the boundary (see
+// Call for how arguments and label hygiene are handled). A Macro must be
+// declared before any Call that references it.
+func Macro(name string, params []Param, body func(args ...Arg) Code) Element {
+	return macroDeclElement{name: name, params: params, body: body}
+}
+
+type macroDeclElement struct {
+	name   string
+	params []Param
+	body   func(args ...Arg) Code
+}
+
+func (m macroDeclElement) appendTo(c *compiler) error {
+	if c.macros == nil {
+		c.macros = map[string]macroDeclElement{}
+	}
+	if _, ok := c.macros[m.name]; ok {
+		return fmt.Errorf("specialops: duplicate Macro(%q)", m.name)
+	}
+	c.macros[m.name] = m
+	return nil
+}
+
+// Call expands the Macro declared with Macro(name, ...) inline. args must
+// match the macro's declared Params positionally: a StackParam arg is an
+// Element, pushed onto the stack (in declared order, before the body runs);
+// a ConstParam arg is any value PUSH accepts, substituted directly into the
+// body wherever it's referenced. Every JUMPDEST the body declares is
+// α-renamed to this call site, so invoking the same macro more than once in
+// one program never collides; PUSHJUMPDEST references to those labels are
+// rewritten along with them, while references to labels declared outside
+// the body pass through untouched.
+func Call(name string, args ...any) Element {
+	return callMacroElement{name: name, args: args}
+}
+
+type callMacroElement struct {
+	name string
+	args []any
+}
+
+func (cm callMacroElement) appendTo(c *compiler) error {
+	m, ok := c.macros[cm.name]
+	if !ok {
+		return fmt.Errorf("specialops: Call(%q): no such Macro", cm.name)
+	}
+	if len(cm.args) != len(m.params) {
+		return fmt.Errorf("specialops: Call(%q): got %d args, want %d", cm.name, len(cm.args), len(m.params))
+	}
+
+	resolved := make([]Arg, len(m.params))
+	for i, p := range m.params {
+		switch p.Kind {
+		case StackParam:
+			expr, ok := cm.args[i].(Element)
+			if !ok {
+				return fmt.Errorf("specialops: Call(%q): arg %d (%s) is a StackParam, need an Element", cm.name, i, p.Name)
+			}
+			end := c.enterSpan(i)
+			if err := expr.appendTo(c); err != nil {
+				return err
+			}
+			end(expr)
+			if c.depth == nil {
+				return fmt.Errorf("specialops: Call(%q): arg %d (%s) left the stack depth unknown", cm.name, i, p.Name)
+			}
+			resolved[i] = stackArgRef{name: p.Name, slotDepth: *c.depth}
+		case ConstParam:
+			resolved[i] = constArgRef{element: PUSH(cm.args[i])}
+		default:
+			return fmt.Errorf("specialops: Call(%q): param %q has unknown ParamKind %d", cm.name, p.Name, p.Kind)
+		}
+	}
+
+	body := m.body(resolved...)
+	labels := map[string]bool{}
+	collectLocalLabels(Code(body), labels)
+	if len(labels) > 0 {
+		id := c.nextCallSiteID()
+		rename := make(map[string]string, len(labels))
+		for l := range labels {
+			rename[l] = fmt.Sprintf("%s$%d", l, id)
+		}
+		body = relabel(Code(body), rename).(Code)
+	}
+	return Code(body).appendTo(c)
+}
+
+// collectLocalLabels gathers every label a JUMPDEST declares directly
+// within el — i.e. not inside some other composite Element's own body,
+// which manages its own labels independently (a nested Sub or Call).
+func collectLocalLabels(el Element, out map[string]bool) {
+	switch v := el.(type) {
+	case Code:
+		for _, e := range v {
+			collectLocalLabels(e, out)
+		}
+	case fnElement:
+		for _, a := range v.args {
+			collectLocalLabels(a, out)
+		}
+	case jumpdestElement:
+		out[v.label] = true
+	}
+}
+
+// relabel returns a copy of el with every JUMPDEST/PUSHJUMPDEST label in
+// rename rewritten, and everything else passed through unchanged.
+func relabel(el Element, rename map[string]string) Element {
+	switch v := el.(type) {
+	case Code:
+		out := make(Code, len(v))
+		for i, e := range v {
+			out[i] = relabel(e, rename)
+		}
+		return out
+	case fnElement:
+		args := make([]Element, len(v.args))
+		for i, a := range v.args {
+			args[i] = relabel(a, rename)
+		}
+		return fnElement{op: v.op, args: args}
+	case jumpdestElement:
+		if r, ok := rename[v.label]; ok {
+			return jumpdestElement{label: r}
+		}
+		return v
+	case pushJumpdestElement:
+		if r, ok := rename[v.label]; ok {
+			return pushJumpdestElement{label: r}
+		}
+		return v
+	default:
+		return el
+	}
+}
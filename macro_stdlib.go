@@ -0,0 +1,73 @@
+package specialops
+
+import "bytes"
+
+// maxUint256 is the 32-byte big-endian encoding of 2**256-1, used by
+// SafeAdd's overflow check.
+var maxUint256 = bytes.Repeat([]byte{0xff}, 32)
+
+// MemcpyCalldata and Keccak256Of are deliberately plain Element-returning
+// functions rather than Macro/Call declarations, unlike SafeAdd and
+// RequireEq below. Macro exists to give a Call site label hygiene and
+// stack-depth bookkeeping at a branch; these two wrap a single opcode with
+// no branch and no StackParam, so Macro/Call would add declare-then-call
+// ceremony without anything for the hygiene machinery to do.
+
+// MemcpyCalldata copies size bytes of calldata starting at offset into
+// memory at destOffset, the idiomatic use of CALLDATACOPY.
+func MemcpyCalldata(destOffset, offset, size int) Element {
+	return Fn(CALLDATACOPY, PUSH(destOffset), PUSH(offset), PUSH(size))
+}
+
+// Keccak256Of hashes the size bytes of memory starting at offset, the
+// idiomatic use of KECCAK256.
+func Keccak256Of(offset, size int) Element {
+	return Fn(KECCAK256, PUSH(offset), PUSH(size))
+}
+
+// SafeAdd declares a "safeAdd" Macro computing a+b, reverting instead of
+// silently wrapping on uint256 overflow. Call it with two StackParam args;
+// it leaves a, b, and the sum on the stack, in that order from the bottom
+// up, the same way CallSub leaves a subroutine's result for its caller to
+// clean up. Declare it once per program (e.g. Code{SafeAdd(), ...}) and
+// Call("safeAdd", a, b) as many times as needed — each call site gets its
+// own copy of the body and its own "ok" label.
+func SafeAdd() Element {
+	return Macro("safeAdd", []Param{
+		{Name: "a", Kind: StackParam},
+		{Name: "b", Kind: StackParam},
+	}, func(args ...Arg) Code {
+		a, b := args[0], args[1]
+		return Code{
+			// overflowed = (MAX_UINT256 - a) < b
+			Fn(JUMPI, PUSHJUMPDEST("ok"), Fn(ISZERO, Fn(LT, Fn(SUB, PUSH(maxUint256), a), b))),
+			Fn(REVERT, PUSH0, PUSH0),
+			JUMPDEST("ok"),
+			// "ok" is reachable only via the JUMPI above, never by falling
+			// through the REVERT, so the linear tracker needs telling what
+			// depth that jump arrives with: exactly what it was before this
+			// check ran, since the check itself is depth-neutral.
+			SetStackDepth(depthOf(b)),
+			Fn(ADD, a, b),
+		}
+	})
+}
+
+// RequireEq declares a "requireEq" Macro that reverts unless its two
+// StackParam args are equal, leaving them both on the stack (the caller's
+// to clean up) if they are. Declare it once per program and
+// Call("requireEq", a, b) as many times as needed, as for SafeAdd.
+func RequireEq() Element {
+	return Macro("requireEq", []Param{
+		{Name: "a", Kind: StackParam},
+		{Name: "b", Kind: StackParam},
+	}, func(args ...Arg) Code {
+		a, b := args[0], args[1]
+		return Code{
+			Fn(JUMPI, PUSHJUMPDEST("ok"), Fn(EQ, a, b)),
+			Fn(REVERT, PUSH0, PUSH0),
+			JUMPDEST("ok"),
+			SetStackDepth(depthOf(b)), // see SafeAdd: "ok" is a jump-only target
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package specialops
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestMacroStackAndConstParams demonstrates both Param kinds in one macro:
+// "shiftRightBy" shifts its StackParam x right by a compile-time ConstParam
+// n, proving the ConstParam substitutes directly (no push) while the
+// StackParam is fetched with a DUP.
+func TestMacroStackAndConstParams(t *testing.T) {
+	shiftRightBy := Macro("shiftRightBy", []Param{
+		{Name: "x", Kind: StackParam},
+		{Name: "n", Kind: ConstParam},
+	}, func(args ...Arg) Code {
+		x, n := args[0], args[1]
+		return Code{Fn(SHR, n, x)}
+	})
+
+	code := Code{
+		shiftRightBy,
+		Fn(CALLDATALOAD, PUSH0),       // <n>
+		Call("shiftRightBy", DUP1, 1), // <n, n, n>>1>
+		Fn(MSTORE, PUSH0),
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+	}
+
+	got, err := code.Run(word(16))
+	if err != nil {
+		t.Fatalf("Run() error %v", err)
+	}
+	if want := word(8); !bytes.Equal(got, want) {
+		t.Errorf("Run() = %#x, want %#x", got, want)
+	}
+}
+
+// TestMacroHygieneAcrossMultipleInvocations calls the same macro twice in
+// one program and proves each call site gets its own label, rather than
+// the second Call colliding with a JUMPDEST the first one already emitted.
+func TestMacroHygieneAcrossMultipleInvocations(t *testing.T) {
+	code := Code{
+		SafeAdd(),
+		Fn(CALLDATALOAD, PUSH0),      // <a>
+		Fn(CALLDATALOAD, PUSH(0x20)), // <a, b>
+		Call("safeAdd", DUP2, DUP2),  // <a, b, a, b, a+b>
+		Fn(MSTORE, PUSH(0x40)),
+		Fn(CALLDATALOAD, PUSH(0x40)), // <a, b, a, b, c>
+		Call("safeAdd", DUP3, DUP2),  // <a, b, a, b, c, a, c, a+c>
+		Fn(MSTORE, PUSH(0x60)),
+		Fn(RETURN, PUSH(0x40), PUSH(0x40)),
+	}
+
+	callData := append(append(word(5), word(10)...), word(7)...)
+	got, err := code.Run(callData)
+	if err != nil {
+		t.Fatalf("Run() error %v", err)
+	}
+	want := append(word(15), word(12)...) // 5+10, 5+7
+	if !bytes.Equal(got, want) {
+		t.Errorf("Run() = %#x, want %#x", got, want)
+	}
+}
+
+// TestRequireEqMacro proves RequireEq reverts on mismatch and falls through
+// on a match, leaving both operands behind.
+func TestRequireEqMacro(t *testing.T) {
+	code := Code{
+		RequireEq(),
+		Fn(CALLDATALOAD, PUSH0),       // <a>
+		Fn(CALLDATALOAD, PUSH(0x20)),  // <a, b>
+		Call("requireEq", DUP2, DUP2), // <a, b, a, b>
+		Fn(RETURN, PUSH0, PUSH0),
+	}
+
+	t.Run("equal", func(t *testing.T) {
+		_, err := code.Run(append(word(9), word(9)...))
+		if err != nil {
+			t.Fatalf("Run() error %v", err)
+		}
+	})
+
+	t.Run("not equal", func(t *testing.T) {
+		_, err := code.Run(append(word(9), word(10)...))
+		if err == nil {
+			t.Fatal("Run() with unequal operands should have failed (REVERT)")
+		}
+	})
+}
+
+// TestMacroDuplicateDeclarationRejected confirms declaring the same Macro
+// name twice is a compile error, mirroring Sub's duplicate-name rejection.
+func TestMacroDuplicateDeclarationRejected(t *testing.T) {
+	code := Code{SafeAdd(), SafeAdd()}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with a duplicate Macro(\"safeAdd\", ...) should have failed")
+	}
+}
+
+// TestCallUndeclaredMacroRejected confirms Call of an unknown macro name is
+// a compile error rather than a silent no-op.
+func TestCallUndeclaredMacroRejected(t *testing.T) {
+	code := Code{Call("noSuchMacro", PUSH(1))}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with Call() of an undeclared macro should have failed")
+	}
+}
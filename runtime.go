@@ -0,0 +1,177 @@
+package specialops
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// contractAddress is where runBytecode deploys compiled bytecode before
+// calling it, matching the fixed address runtime.Execute uses internally.
+var contractAddress = common.BytesToAddress([]byte("contract"))
+
+// RunOption configures the environment Code.Run executes compiled bytecode
+// against. The zero-value environment (no options) is a fresh, in-memory
+// EVM state with go-ethereum's standard precompiles, a zero-value caller
+// and block context, and no pre-seeded storage or balances.
+type RunOption func(*runConfig)
+
+// BlockContext is the subset of block data a RunOption can set for code
+// that reads NUMBER/TIMESTAMP, or whose behaviour otherwise depends on them
+// (e.g. BASEFEE, PREVRANDAO).
+type BlockContext struct {
+	Number *big.Int
+	Time   uint64
+}
+
+// WithBlockContext sets the block number and time Code.Run executes
+// against.
+func WithBlockContext(bc BlockContext) RunOption {
+	return func(rc *runConfig) {
+		rc.cfg.BlockNumber = bc.Number
+		rc.cfg.Time = bc.Time
+	}
+}
+
+// WithCaller sets the address Code.Run executes as if called by, i.e. the
+// account CALLER and ORIGIN report.
+func WithCaller(addr common.Address) RunOption {
+	return func(rc *runConfig) { rc.cfg.Origin = addr }
+}
+
+// WithBalance seeds addr with bal before Code.Run executes, for code that
+// reads BALANCE or calls elsewhere with value. addr starts with no balance,
+// so this is equivalent to setting it outright.
+func WithBalance(addr common.Address, bal *uint256.Int) RunOption {
+	return func(rc *runConfig) {
+		rc.seeds = append(rc.seeds, func(s vm.StateDB) {
+			s.AddBalance(addr, bal, tracing.BalanceIncreaseGenesisBalance)
+		})
+	}
+}
+
+// WithStorage seeds addr's storage slot key with value before Code.Run
+// executes. SLOAD reads only the executing contract's own storage, which is
+// that of contractAddress.
+func WithStorage(addr common.Address, key, value common.Hash) RunOption {
+	return func(rc *runConfig) {
+		rc.seeds = append(rc.seeds, func(s vm.StateDB) {
+			s.SetState(addr, key, value)
+		})
+	}
+}
+
+// WithPrecompile registers a precompiled contract at addr, in addition to
+// go-ethereum's standard set (ECRECOVER, SHA256, IDENTITY, ...), letting
+// tests exercise code that CALLs/STATICCALLs/DELEGATECALLs out to it. gas
+// is charged before run is invoked, exactly as for a built-in precompile.
+func WithPrecompile(addr common.Address, run func(input []byte) ([]byte, error), gas func(len int) uint64) RunOption {
+	return func(rc *runConfig) {
+		rc.precompiles[addr] = precompile{run: run, gas: gas}
+	}
+}
+
+type precompile struct {
+	run func(input []byte) ([]byte, error)
+	gas func(len int) uint64
+}
+
+func (p precompile) RequiredGas(input []byte) uint64  { return p.gas(len(input)) }
+func (p precompile) Run(input []byte) ([]byte, error) { return p.run(input) }
+func (p precompile) Name() string                     { return "specialops custom precompile" }
+
+// runConfig accumulates what every RunOption contributes, for runBytecode
+// to apply in one pass once they've all been collected.
+type runConfig struct {
+	cfg         *runtime.Config
+	seeds       []func(vm.StateDB)
+	precompiles vm.PrecompiledContracts
+}
+
+func newRunConfig() *runConfig {
+	return &runConfig{cfg: &runtime.Config{}, precompiles: vm.PrecompiledContracts{}}
+}
+
+// applyDefaults fills every field runBytecode depends on that no RunOption
+// set, mirroring the defaults runtime.Execute itself would otherwise apply.
+// It's needed because building the EVM by hand (to get at SetPrecompiles)
+// bypasses that codepath entirely.
+func (rc *runConfig) applyDefaults() error {
+	cfg := rc.cfg
+	if cfg.ChainConfig == nil {
+		cfg.ChainConfig = params.AllDevChainProtocolChanges
+	}
+	if cfg.Difficulty == nil {
+		cfg.Difficulty = new(big.Int)
+	}
+	if cfg.GasLimit == 0 {
+		cfg.GasLimit = math.MaxUint64
+	}
+	if cfg.GasPrice == nil {
+		cfg.GasPrice = new(big.Int)
+	}
+	if cfg.Value == nil {
+		cfg.Value = new(big.Int)
+	}
+	if cfg.BlockNumber == nil {
+		cfg.BlockNumber = new(big.Int)
+	}
+	if cfg.BaseFee == nil {
+		cfg.BaseFee = new(big.Int)
+	}
+	if cfg.Random == nil {
+		// Non-nil signals the chain is post-merge, which is what gates every
+		// fork-specific instruction set (Shanghai's PUSH0 included) on.
+		cfg.Random = new(common.Hash)
+	}
+	if cfg.State == nil {
+		s, err := state.New(types.EmptyRootHash, state.NewDatabaseForTesting())
+		if err != nil {
+			return err
+		}
+		cfg.State = s
+	}
+	return nil
+}
+
+// runBytecode executes compiled against an EVM environment assembled from
+// opts and returns the call's return data. It underlies Code.Run().
+func runBytecode(compiled, callData []byte, opts ...RunOption) ([]byte, error) {
+	rc := newRunConfig()
+	for _, opt := range opts {
+		opt(rc)
+	}
+	if err := rc.applyDefaults(); err != nil {
+		return nil, err
+	}
+
+	vmenv := runtime.NewEnv(rc.cfg)
+	rules := vmenv.ChainConfig().Rules(vmenv.Context.BlockNumber, vmenv.Context.Random != nil, vmenv.Context.Time)
+
+	// The contract account must exist before seeds run, or a seeded SLOAD on
+	// contractAddress would be wiped out by the CreateAccount below it.
+	vmenv.StateDB.CreateAccount(contractAddress)
+	for _, seed := range rc.seeds {
+		seed(vmenv.StateDB)
+	}
+	if len(rc.precompiles) > 0 {
+		all := vm.ActivePrecompiledContracts(rules)
+		for addr, p := range rc.precompiles {
+			all[addr] = p
+		}
+		vmenv.SetPrecompiles(all)
+	}
+
+	vmenv.StateDB.Prepare(rules, rc.cfg.Origin, rc.cfg.Coinbase, &contractAddress, vm.ActivePrecompiles(rules), nil)
+	vmenv.StateDB.SetCode(contractAddress, compiled, tracing.CodeChangeUnspecified)
+	ret, _, err := vmenv.Call(rc.cfg.Origin, contractAddress, callData, vm.NewGasBudget(rc.cfg.GasLimit, 0), uint256.MustFromBig(rc.cfg.Value))
+	return ret, err
+}
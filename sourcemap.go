@@ -0,0 +1,143 @@
+package specialops
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// SourceSpan describes the bytecode range [PC, EndPC) emitted by a single
+// Code element. Path locates that element: Path[0] is its index in the
+// top-level Code slice (or, inside a Sub body, in that body's slice —
+// see Sub; a Sub's own entry JUMPDEST has Path [-1]), and each subsequent
+// entry descends one level into a nested element: a Fn(...)'s declared
+// argument list, a nested Code slice, or a Call's arguments and expanded
+// macro body (both numbered from 0). A JUMPDEST or PUSHJUMPDEST span additionally
+// carries the label it declares or references, for tools that want to
+// resolve jumps without re-parsing Description.
+type SourceSpan struct {
+	PC, EndPC   int
+	Path        []int  `json:"path"`
+	Sub         string `json:"sub,omitempty"`        // non-empty inside a Sub(name, ...) body
+	Label       string `json:"label,omitempty"`      // set on a JUMPDEST(label) span
+	JumpTarget  string `json:"jumpTarget,omitempty"` // set on a PUSHJUMPDEST(label) span
+	Description string `json:"description"`
+}
+
+// SourceMap is every SourceSpan produced by a single Compile, in emission
+// order (which, because Sub bodies are hoisted to the end of the program,
+// isn't always program order).
+type SourceMap []SourceSpan
+
+// JSON renders m for consumption by tools other than this package, e.g. an
+// editor plugin correlating a PC with the DSL that produced it.
+func (m SourceMap) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// spanAt returns the innermost span covering pc: the one among those
+// containing pc whose range is narrowest, i.e. the most specific Fn(...)
+// argument rather than the group as a whole.
+func (m SourceMap) spanAt(pc int) (SourceSpan, bool) {
+	var best SourceSpan
+	found := false
+	for _, s := range m {
+		if pc < s.PC || pc >= s.EndPC {
+			continue
+		}
+		if !found || s.EndPC-s.PC < best.EndPC-best.PC {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
+
+// enterSpan marks the start of compiling the idx'th element at the
+// compiler's current nesting level, returning a function to call once that
+// element has finished compiling, which records the resulting span. It's a
+// no-op (the returned function does nothing) unless c.srcMap is set, i.e.
+// unless CompileWithSourceMap is building one.
+func (c *compiler) enterSpan(idx int) func(el Element) {
+	if c.srcMap == nil {
+		return func(Element) {}
+	}
+	c.path = append(c.path, idx)
+	start := len(c.buf)
+	return func(el Element) {
+		path := append([]int(nil), c.path...)
+		*c.srcMap = append(*c.srcMap, SourceSpan{
+			PC:          start,
+			EndPC:       len(c.buf),
+			Path:        path,
+			Sub:         c.subName,
+			Label:       labelOf(el),
+			JumpTarget:  jumpTargetOf(el),
+			Description: describeElement(el),
+		})
+		c.path = c.path[:len(c.path)-1]
+	}
+}
+
+func labelOf(el Element) string {
+	if j, ok := el.(jumpdestElement); ok {
+		return j.label
+	}
+	return ""
+}
+
+func jumpTargetOf(el Element) string {
+	if p, ok := el.(pushJumpdestElement); ok {
+		return p.label
+	}
+	return ""
+}
+
+// describeElement renders el the way Debug displays it: short enough for a
+// single status line, unlike disasm.go's elementGoString which renders
+// gofmt-able source.
+func describeElement(el Element) string {
+	switch v := el.(type) {
+	case opCode:
+		return vm.OpCode(v).String()
+	case fnElement:
+		return fmt.Sprintf("Fn(%s, ...)", vm.OpCode(v.op).String())
+	case jumpdestElement:
+		return fmt.Sprintf("JUMPDEST(%q)", v.label)
+	case pushJumpdestElement:
+		return fmt.Sprintf("PUSHJUMPDEST(%q)", v.label)
+	case pushBytesElement:
+		return fmt.Sprintf("PUSH(%#x)", v.data)
+	case stackDepthElement:
+		if v.assert {
+			return fmt.Sprintf("ExpectStackDepth(%d)", v.depth)
+		}
+		return fmt.Sprintf("SetStackDepth(%d)", v.depth)
+	case invertedElement:
+		return fmt.Sprintf("Inverted(%s)", vm.OpCode(v.op).String())
+	case subElement:
+		return fmt.Sprintf("Sub(%q, ...)", v.name)
+	case callSubElement:
+		return fmt.Sprintf("CallSub(%q)", v.name)
+	case retSubElement:
+		return "RetSub()"
+	case macroDeclElement:
+		return fmt.Sprintf("Macro(%q, ...)", v.name)
+	case callMacroElement:
+		return fmt.Sprintf("Call(%q, ...)", v.name)
+	default:
+		return fmt.Sprintf("%T", el)
+	}
+}
+
+// CompileWithSourceMap behaves like Compile but also returns a SourceMap
+// recording which Code element produced each byte range, for tools such as
+// Debug that need to map a running PC back to the DSL that emitted it.
+func (code Code) CompileWithSourceMap() ([]byte, SourceMap, error) {
+	var sm SourceMap
+	c, err := compile(code, &sm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.buf, sm, nil
+}
@@ -0,0 +1,62 @@
+package specialops
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestCompileWithSourceMap proves that spans nest the way the DSL does: a
+// Fn(...)'s span covers its whole expansion, including the spans of its
+// arguments, and that Compile and CompileWithSourceMap agree on bytecode.
+func TestCompileWithSourceMap(t *testing.T) {
+	code := Code{
+		Fn(MSTORE, PUSH0, Fn(ADD, PUSH(1), PUSH(2))), // <> {1+2}
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+	}
+
+	want, err := code.Compile()
+	if err != nil {
+		t.Fatalf("Compile() error %v", err)
+	}
+	got, sm, err := code.CompileWithSourceMap()
+	if err != nil {
+		t.Fatalf("CompileWithSourceMap() error %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("CompileWithSourceMap() bytecode = %#x, want %#x (from Compile())", got, want)
+	}
+
+	var outer, inner SourceSpan
+	for _, s := range sm {
+		switch {
+		case len(s.Path) == 1 && s.Path[0] == 0:
+			outer = s
+		case len(s.Path) == 2 && s.Path[0] == 0 && s.Path[1] == 1:
+			inner = s
+		}
+	}
+	if outer.EndPC == 0 {
+		t.Fatal("no span for the top-level Fn(MSTORE, ...) (Path [0])")
+	}
+	if inner.EndPC == 0 {
+		t.Fatal("no span for its nested Fn(ADD, ...) argument (Path [0 1])")
+	}
+	if inner.PC < outer.PC || inner.EndPC > outer.EndPC {
+		t.Errorf("nested span [%d,%d) is not contained within its parent's span [%d,%d)", inner.PC, inner.EndPC, outer.PC, outer.EndPC)
+	}
+	// The MSTORE opcode itself follows the nested arg's span but is only
+	// covered by the outer span, since fnElement.appendTo doesn't wrap the
+	// op it emits in its own span.
+	if inner.EndPC >= outer.EndPC {
+		t.Errorf("nested span end %d leaves no room in the parent for the MSTORE opcode (parent ends at %d)", inner.EndPC, outer.EndPC)
+	}
+
+	if _, err := sm.JSON(); err != nil {
+		t.Errorf("JSON() error %v", err)
+	}
+	b, _ := sm.JSON()
+	var roundTrip SourceMap
+	if err := json.Unmarshal(b, &roundTrip); err != nil {
+		t.Errorf("json.Unmarshal(JSON()) error %v", err)
+	}
+}
@@ -0,0 +1,524 @@
+// Package specialops is a small DSL for hand-writing EVM bytecode. A
+// program is expressed as a Code slice mixing raw opcodes with helpers such
+// as Fn, JUMPDEST, PUSHJUMPDEST, and PUSH, then lowered to bytes by
+// Code.Compile() and executed with Code.Run().
+package specialops
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// opCode is a local wrapper around vm.OpCode so the DSL can hang Element
+// behaviour off of bare opcode values (e.g. writing CALLDATASIZE directly
+// as a Code element).
+type opCode vm.OpCode
+
+const (
+	STOP           = opCode(vm.STOP)
+	ADD            = opCode(vm.ADD)
+	SUB            = opCode(vm.SUB)
+	EQ             = opCode(vm.EQ)
+	LT             = opCode(vm.LT)
+	ISZERO         = opCode(vm.ISZERO)
+	SHR            = opCode(vm.SHR)
+	KECCAK256      = opCode(vm.KECCAK256)
+	ADDRESS        = opCode(vm.ADDRESS)
+	BALANCE        = opCode(vm.BALANCE)
+	CALLER         = opCode(vm.CALLER)
+	CALLVALUE      = opCode(vm.CALLVALUE)
+	CALLDATALOAD   = opCode(vm.CALLDATALOAD)
+	CALLDATASIZE   = opCode(vm.CALLDATASIZE)
+	CALLDATACOPY   = opCode(vm.CALLDATACOPY)
+	RETURNDATASIZE = opCode(vm.RETURNDATASIZE)
+	RETURNDATACOPY = opCode(vm.RETURNDATACOPY)
+	POP            = opCode(vm.POP)
+	MLOAD          = opCode(vm.MLOAD)
+	MSTORE         = opCode(vm.MSTORE)
+	MSTORE8        = opCode(vm.MSTORE8)
+	SLOAD          = opCode(vm.SLOAD)
+	SSTORE         = opCode(vm.SSTORE)
+	JUMP           = opCode(vm.JUMP)
+	JUMPI          = opCode(vm.JUMPI)
+	PC             = opCode(vm.PC)
+	GAS            = opCode(vm.GAS)
+	jumpdestOp     = opCode(vm.JUMPDEST)
+	PUSH0          = opCode(vm.PUSH0)
+	RETURN         = opCode(vm.RETURN)
+	REVERT         = opCode(vm.REVERT)
+	CALL           = opCode(vm.CALL)
+	DELEGATECALL   = opCode(vm.DELEGATECALL)
+	STATICCALL     = opCode(vm.STATICCALL)
+
+	DUP1  = opCode(vm.DUP1)
+	DUP2  = opCode(vm.DUP2)
+	DUP3  = opCode(vm.DUP3)
+	DUP4  = opCode(vm.DUP4)
+	DUP5  = opCode(vm.DUP5)
+	DUP6  = opCode(vm.DUP6)
+	DUP7  = opCode(vm.DUP7)
+	DUP8  = opCode(vm.DUP8)
+	DUP9  = opCode(vm.DUP9)
+	DUP10 = opCode(vm.DUP10)
+	DUP11 = opCode(vm.DUP11)
+	DUP12 = opCode(vm.DUP12)
+	DUP13 = opCode(vm.DUP13)
+	DUP14 = opCode(vm.DUP14)
+	DUP15 = opCode(vm.DUP15)
+	DUP16 = opCode(vm.DUP16)
+
+	SWAP1  = opCode(vm.SWAP1)
+	SWAP2  = opCode(vm.SWAP2)
+	SWAP3  = opCode(vm.SWAP3)
+	SWAP4  = opCode(vm.SWAP4)
+	SWAP5  = opCode(vm.SWAP5)
+	SWAP6  = opCode(vm.SWAP6)
+	SWAP7  = opCode(vm.SWAP7)
+	SWAP8  = opCode(vm.SWAP8)
+	SWAP9  = opCode(vm.SWAP9)
+	SWAP10 = opCode(vm.SWAP10)
+	SWAP11 = opCode(vm.SWAP11)
+	SWAP12 = opCode(vm.SWAP12)
+	SWAP13 = opCode(vm.SWAP13)
+	SWAP14 = opCode(vm.SWAP14)
+	SWAP15 = opCode(vm.SWAP15)
+	SWAP16 = opCode(vm.SWAP16)
+)
+
+// stackEffect returns the number of items op pops and pushes, for opcodes
+// the compiler knows how to reason about statically. PUSHn/DUPn/SWAPn are
+// handled by range checks rather than being listed individually.
+func stackEffect(op opCode) (pop, push int, ok bool) {
+	switch {
+	case op >= opCode(vm.PUSH1) && op <= opCode(vm.PUSH32), op == PUSH0, op == PC,
+		op == CALLDATASIZE, op == CALLER, op == CALLVALUE, op == ADDRESS,
+		op == GAS, op == RETURNDATASIZE:
+		return 0, 1, true
+	case op >= DUP1 && op <= DUP16:
+		return 0, 1, true
+	case op >= SWAP1 && op <= SWAP16:
+		return 0, 0, true
+	case op == CALLDATALOAD, op == ISZERO, op == MLOAD, op == SLOAD, op == BALANCE:
+		return 1, 1, true
+	case op == ADD, op == SUB, op == EQ, op == LT, op == SHR, op == KECCAK256:
+		return 2, 1, true
+	case op == MSTORE, op == MSTORE8, op == SSTORE, op == JUMPI, op == RETURN, op == REVERT:
+		return 2, 0, true
+	case op == CALLDATACOPY, op == RETURNDATACOPY:
+		return 3, 0, true
+	case op == JUMP, op == POP:
+		return 1, 0, true
+	case op == jumpdestOp, op == STOP:
+		return 0, 0, true
+	case op == STATICCALL, op == DELEGATECALL:
+		return 6, 1, true
+	case op == CALL:
+		return 7, 1, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// Element is a single item of a Code program: either a raw opcode or a DSL
+// construct (Fn, JUMPDEST, PUSHJUMPDEST, PUSH, ...) that expands to zero or
+// more opcodes at compile time.
+type Element interface {
+	appendTo(c *compiler) error
+}
+
+// Code is a specialops program, compiled with Compile and executed with Run.
+type Code []Element
+
+func (c Code) appendTo(cc *compiler) error {
+	for i, el := range c {
+		end := cc.enterSpan(i)
+		if err := el.appendTo(cc); err != nil {
+			return err
+		}
+		end(el)
+	}
+	return nil
+}
+
+// fixup is a forward or backward reference to a label that can only be
+// resolved once the whole program has been walked.
+type fixup struct {
+	label string
+	pos   int // byte offset of the 2-byte destination operand
+}
+
+// compiler accumulates bytecode and label bookkeeping as it walks a Code
+// tree. It is also the extension point subsequent features (subroutines,
+// macros, the stack verifier) hang additional passes off of.
+type compiler struct {
+	buf    []byte
+	labels map[string]int
+	fixups []fixup
+
+	depth    *int // current known stack depth, nil if not statically known
+	depthLog []depthEvent
+
+	callSiteCounter int
+
+	subs      map[string]Code
+	subOrder  []string
+	inSubBody bool
+
+	// macros holds every Macro declared so far during the walk, keyed by
+	// name; see macro.go. Unlike subs, there's no hoisting or ordering to
+	// track — Call expands a macro's body inline as soon as it's seen.
+	macros map[string]macroDeclElement
+
+	// verify is non-nil while Compile() is checking stack-depth consistency
+	// across JUMPDEST predecessors; see verify.go.
+	verify        *verifier
+	lastJumpdest  string // label most recently declared, if nothing's appended since
+	lastLabelPush string // label most recently PUSHJUMPDEST'd, if not yet consumed by a JUMP/JUMPI
+
+	// srcMap is non-nil while CompileWithSourceMap() is recording which
+	// Code element produced each byte range; see sourcemap.go. path and
+	// subName track where in the Code tree the walk currently is, for
+	// labelling the spans srcMap accumulates.
+	srcMap  *SourceMap
+	path    []int
+	subName string // non-empty while appending a Sub's hoisted body
+}
+
+type depthEvent struct {
+	pc     int
+	depth  int
+	assert bool
+}
+
+func newCompiler() *compiler {
+	zero := 0
+	return &compiler{labels: map[string]int{}, depth: &zero}
+}
+
+func (c *compiler) emit(b ...byte) {
+	c.buf = append(c.buf, b...)
+}
+
+func (c *compiler) bumpDepth(delta int) {
+	if c.depth == nil {
+		return
+	}
+	*c.depth += delta
+}
+
+func (c *compiler) nextCallSiteID() int {
+	c.callSiteCounter++
+	return c.callSiteCounter
+}
+
+// isTerminal reports whether op ends a basic block outright, so that
+// whatever bytes follow it in the program are never reached by falling
+// through from op (they're either dead code or the target of some other
+// jump, either way not something op's depth says anything about).
+func isTerminal(op opCode) bool {
+	switch op {
+	case STOP, RETURN, REVERT, JUMP:
+		return true
+	default:
+		return false
+	}
+}
+
+func (o opCode) appendTo(c *compiler) error {
+	c.lastJumpdest = ""
+	c.emit(byte(o))
+	pop, push, ok := stackEffect(o)
+	if ok {
+		c.bumpDepth(push - pop)
+	} else {
+		c.depth = nil
+	}
+
+	if o == JUMP || o == JUMPI {
+		if c.verify != nil && c.lastLabelPush != "" && c.depth != nil {
+			c.verify.recordArrival(c.lastLabelPush, *c.depth, "jump")
+		}
+	}
+	// lastLabelPush only describes the value a JUMP/JUMPI would consume if
+	// it were the very next element; anything else, even a no-op for
+	// verification purposes like SetStackDepth, means whatever's on the
+	// stack was pushed for some other reason (or discarded), so it can no
+	// longer be attributed to this jump destination.
+	c.lastLabelPush = ""
+	if isTerminal(o) {
+		// Nothing textually after a STOP/RETURN/REVERT/JUMP is reached by
+		// falling through from here, so stop claiming to know its depth.
+		c.depth = nil
+	}
+	return nil
+}
+
+// Fn treats op as a function call: each of args is compiled first (each is
+// expected to leave exactly one value on the stack), then op itself is
+// emitted. Nesting Fn calls reads like nested function calls in a
+// conventional language, e.g. Fn(MSTORE, PUSH0, Fn(KECCAK256, PUSH0)).
+func Fn(op opCode, args ...Element) Element {
+	return fnElement{op: op, args: args}
+}
+
+type fnElement struct {
+	op   opCode
+	args []Element
+}
+
+// appendTo pushes args in reverse declared order, so that the *declared*
+// order matches op's natural pop order (the first argument ends up as the
+// operand op pops first). This is what lets Fn(SUB, CALLDATASIZE, PUSH(1))
+// read left-to-right as "CALLDATASIZE - 1".
+func (f fnElement) appendTo(c *compiler) error {
+	for i := len(f.args) - 1; i >= 0; i-- {
+		end := c.enterSpan(i)
+		if err := f.args[i].appendTo(c); err != nil {
+			return err
+		}
+		end(f.args[i])
+	}
+	return f.op.appendTo(c)
+}
+
+// JUMPDEST declares a named jump destination. Labels are unique within a
+// single Compile().
+func JUMPDEST(label string) Element {
+	return jumpdestElement{label: label}
+}
+
+type jumpdestElement struct{ label string }
+
+func (j jumpdestElement) appendTo(c *compiler) error {
+	if _, exists := c.labels[j.label]; exists {
+		return fmt.Errorf("specialops: duplicate JUMPDEST(%q)", j.label)
+	}
+	c.labels[j.label] = len(c.buf)
+	if c.verify != nil && c.depth != nil {
+		c.verify.recordArrival(j.label, *c.depth, "fall-through")
+	}
+	if err := jumpdestOp.appendTo(c); err != nil {
+		return err
+	}
+	c.lastJumpdest = j.label
+	return nil
+}
+
+// PUSHJUMPDEST pushes the address of label, which may be declared earlier
+// or later in the program. It always compiles to a fixed-width PUSH2,
+// giving the compiler a stable byte offset to patch once every label has
+// been seen.
+func PUSHJUMPDEST(label string) Element {
+	return pushJumpdestElement{label: label}
+}
+
+type pushJumpdestElement struct{ label string }
+
+func (p pushJumpdestElement) appendTo(c *compiler) error {
+	c.lastJumpdest = ""
+	c.fixups = append(c.fixups, fixup{label: p.label, pos: len(c.buf) + 1})
+	c.emit(byte(vm.PUSH2), 0, 0)
+	c.bumpDepth(1)
+	c.lastLabelPush = p.label
+	return nil
+}
+
+// PUSH pushes a constant onto the stack. Accepted types are int (encoded
+// with the minimal number of bytes, always at least one, so PUSH(0) is
+// PUSH1 0x00 as opposed to the PUSH0 opcode), []byte (encoded verbatim,
+// padded to at least one byte), types with a `Bytes() []byte` method such
+// as *uint256.Int (including values of such a type, e.g. uint256.Int,
+// whose method is declared on the pointer receiver), and string (syntactic
+// sugar for PUSHJUMPDEST).
+func PUSH(v any) Element {
+	switch t := v.(type) {
+	case string:
+		return PUSHJUMPDEST(t)
+	case []byte:
+		return pushBytesElement{data: t}
+	case int:
+		return pushBytesElement{data: minimalBytes(uint64(t))}
+	case uint64:
+		return pushBytesElement{data: minimalBytes(t)}
+	default:
+		if b, ok := v.(interface{ Bytes() []byte }); ok {
+			return pushBytesElement{data: b.Bytes()}
+		}
+		// Bytes() is commonly declared on a pointer receiver (e.g.
+		// *uint256.Int), so a bare value of such a type won't satisfy the
+		// interface above. Take its address and retry before giving up.
+		if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr {
+			addr := reflect.New(rv.Type())
+			addr.Elem().Set(rv)
+			if b, ok := addr.Interface().(interface{ Bytes() []byte }); ok {
+				return pushBytesElement{data: b.Bytes()}
+			}
+		}
+		panic(fmt.Sprintf("specialops: PUSH(%T) not supported", v))
+	}
+}
+
+func minimalBytes(v uint64) []byte {
+	if v == 0 {
+		return []byte{0}
+	}
+	var buf []byte
+	for v > 0 {
+		buf = append([]byte{byte(v)}, buf...)
+		v >>= 8
+	}
+	return buf
+}
+
+type pushBytesElement struct{ data []byte }
+
+func (p pushBytesElement) appendTo(c *compiler) error {
+	c.lastJumpdest = ""
+	data := p.data
+	if len(data) == 0 {
+		data = []byte{0}
+	}
+	if len(data) > 32 {
+		return fmt.Errorf("specialops: PUSH of %d bytes exceeds PUSH32", len(data))
+	}
+	c.emit(byte(vm.PUSH1) + byte(len(data)-1))
+	c.emit(data...)
+	c.bumpDepth(1)
+	return nil
+}
+
+// ExpectStackDepth asserts that exactly n items are expected to be on the
+// stack at this point in the program. It is a documentation aid and, from
+// the stack verifier onward, a compile-time check; it emits no bytecode.
+func ExpectStackDepth(n int) Element { return stackDepthElement{depth: n, assert: true} }
+
+// SetStackDepth overrides the compiler's statically tracked stack depth,
+// for use at points the linear tracker cannot reason about on its own
+// (e.g. immediately after a JUMPDEST reachable from multiple predecessors).
+func SetStackDepth(n int) Element { return stackDepthElement{depth: n} }
+
+type stackDepthElement struct {
+	depth  int
+	assert bool
+}
+
+func (s stackDepthElement) appendTo(c *compiler) error {
+	if s.assert {
+		if c.depth != nil && *c.depth != s.depth {
+			return fmt.Errorf("specialops: ExpectStackDepth(%d) but computed depth is %d", s.depth, *c.depth)
+		}
+	} else {
+		d := s.depth
+		c.depth = &d
+	}
+	if c.verify != nil && c.lastJumpdest != "" {
+		source := "SetStackDepth"
+		if s.assert {
+			source = "ExpectStackDepth"
+		}
+		c.verify.recordArrival(c.lastJumpdest, s.depth, source)
+	}
+	c.depthLog = append(c.depthLog, depthEvent{pc: len(c.buf), depth: s.depth, assert: s.assert})
+	// Like any element other than a JUMP/JUMPI consuming it, SetStackDepth
+	// leaves whatever was pushed in place without jumping to it.
+	c.lastLabelPush = ""
+	return nil
+}
+
+// Inverted rewrites a DUPn or SWAPn opcode to count from the bottom of the
+// stack instead of the top, using the compiler's statically tracked depth
+// at this point (see SetStackDepth if that depth isn't known already). With
+// more than 16 items on the stack it counts from the bottom of the top 16,
+// the deepest items DUP16/SWAP16 can reach.
+func Inverted(op opCode) Element {
+	return invertedElement{op: op}
+}
+
+type invertedElement struct{ op opCode }
+
+func (inv invertedElement) appendTo(c *compiler) error {
+	if c.depth == nil {
+		return fmt.Errorf("specialops: Inverted(%v) needs a known stack depth; add a SetStackDepth", inv.op)
+	}
+	d := *c.depth
+	window := d
+	if window > 16 {
+		window = 16
+	}
+	switch {
+	case inv.op >= DUP1 && inv.op <= DUP16:
+		i := int(inv.op - DUP1)
+		j := window - i
+		if j < 1 || j > 16 {
+			return fmt.Errorf("specialops: Inverted(%v) at depth %d is out of range", inv.op, d)
+		}
+		return (DUP1 + opCode(j-1)).appendTo(c)
+	case inv.op >= SWAP1 && inv.op <= SWAP16:
+		i := int(inv.op - SWAP1)
+		k := window - 1 - i
+		if k < 1 || k > 16 {
+			return fmt.Errorf("specialops: Inverted(%v) at depth %d is out of range", inv.op, d)
+		}
+		return (SWAP1 + opCode(k-1)).appendTo(c)
+	default:
+		return fmt.Errorf("specialops: Inverted() only supports DUPn/SWAPn, got %v", inv.op)
+	}
+}
+
+// compile runs the shared compile pipeline (walk code, hoist Sub bodies,
+// verify stack balance, patch fixups), optionally recording a SourceMap
+// into srcMap. Compile and CompileWithSourceMap are thin wrappers around it
+// that differ only in whether they pass a non-nil srcMap.
+func compile(code Code, srcMap *SourceMap) (*compiler, error) {
+	c := newCompiler()
+	c.verify = newVerifier()
+	c.srcMap = srcMap
+	if err := code.appendTo(c); err != nil {
+		return nil, err
+	}
+	if err := c.emitHoistedSubs(); err != nil {
+		return nil, err
+	}
+	if err := c.verify.check(); err != nil {
+		return nil, err
+	}
+	for _, f := range c.fixups {
+		dest, ok := c.labels[f.label]
+		if !ok {
+			return nil, fmt.Errorf("specialops: reference to undeclared label %q", f.label)
+		}
+		if dest > 0xffff {
+			return nil, fmt.Errorf("specialops: label %q at offset %d overflows PUSH2", f.label, dest)
+		}
+		c.buf[f.pos] = byte(dest >> 8)
+		c.buf[f.pos+1] = byte(dest)
+	}
+	return c, nil
+}
+
+// Compile lowers code to EVM bytecode, resolving every JUMPDEST/PUSHJUMPDEST
+// pair and running any registered compiler passes (subroutine lowering,
+// macro expansion, the stack verifier) along the way. See
+// CompileWithSourceMap for a version that also reports which Code element
+// produced each byte range.
+func (code Code) Compile() ([]byte, error) {
+	c, err := compile(code, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.buf, nil
+}
+
+// Run compiles code and executes it against callData, returning the
+// contract's return data. With no opts, it runs against a bare-bones EVM;
+// see RunOption to configure its caller, state, precompiles, or block
+// context.
+func (code Code) Run(callData []byte, opts ...RunOption) ([]byte, error) {
+	compiled, err := code.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return runBytecode(compiled, callData, opts...)
+}
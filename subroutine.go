@@ -0,0 +1,119 @@
+package specialops
+
+import "fmt"
+
+// Sub declares a named, reusable subroutine. The body is compiled once, at
+// the end of the program, guarded by a leading JUMPDEST(name); use CallSub
+// to invoke it from anywhere else in the program, including recursively
+// from within body itself. Sub may appear anywhere in a top-level Code
+// slice — its position only affects where JUMPDEST(name) would have been
+// emitted inline, which is irrelevant since the body is always hoisted.
+func Sub(name string, body ...Element) Element {
+	return subElement{name: name, body: Code(body)}
+}
+
+type subElement struct {
+	name string
+	body Code
+}
+
+func (s subElement) appendTo(c *compiler) error {
+	if c.inSubBody {
+		return fmt.Errorf("specialops: Sub(%q) declared inside another Sub's body; nested Sub definitions are not supported", s.name)
+	}
+	if c.subs == nil {
+		c.subs = map[string]Code{}
+	}
+	if _, ok := c.subs[s.name]; ok {
+		return fmt.Errorf("specialops: duplicate Sub(%q)", s.name)
+	}
+	c.subs[s.name] = s.body
+	c.subOrder = append(c.subOrder, s.name)
+	return nil
+}
+
+// CallSub invokes the subroutine declared with Sub(name, ...). It pushes a
+// fresh return label, jumps into the subroutine's body, and resumes
+// immediately after once the body's RetSub() runs. Multiple CallSub sites
+// for the same name — including a call from within the subroutine's own
+// body, i.e. recursion — each get their own return label, so they don't
+// interfere with one another.
+func CallSub(name string) Element {
+	return callSubElement{name: name}
+}
+
+type callSubElement struct{ name string }
+
+func (cs callSubElement) appendTo(c *compiler) error {
+	ret := fmt.Sprintf("%s$ret%d", cs.name, c.nextCallSiteID())
+	if err := PUSHJUMPDEST(ret).appendTo(c); err != nil { // <return label> pushed for RetSub() to consume
+		return err
+	}
+	if err := PUSHJUMPDEST(cs.name).appendTo(c); err != nil { // <return label, sub entry>
+		return err
+	}
+	// Unlike an ordinary PUSHJUMPDEST+JUMP pair, this one doesn't tell the
+	// verifier anything useful: Sub's entry JUMPDEST is reached from
+	// arbitrarily many call sites, each with arbitrarily different absolute
+	// stack depth (callers only agree on what they leave on top of the
+	// stack for the subroutine, not on what's underneath), so it's exempt
+	// from the usual requirement that a label's arrivals agree.
+	c.lastLabelPush = ""
+	if err := JUMP.appendTo(c); err != nil { // <return label>, now executing inside the subroutine
+		return err
+	}
+	if err := JUMPDEST(ret).appendTo(c); err != nil {
+		return err
+	}
+	// The continuation's depth depends on what the subroutine body leaves
+	// behind, which this call site can't see; require an explicit
+	// SetStackDepth if the linear tracker is needed past this point.
+	c.depth = nil
+	return nil
+}
+
+// RetSub returns from the subroutine currently being compiled, consuming
+// the return label CallSub left on top of the stack. A body may contain
+// multiple RetSub()s for multiple return points.
+func RetSub() Element {
+	return retSubElement{}
+}
+
+type retSubElement struct{}
+
+func (retSubElement) appendTo(c *compiler) error {
+	if !c.inSubBody {
+		return fmt.Errorf("specialops: RetSub() outside of a Sub(...) body")
+	}
+	return JUMP.appendTo(c)
+}
+
+// emitHoistedSubs emits every Sub declared during the walk of the top-level
+// Code, once each, after the main program. It's called once by
+// Code.Compile() after the top-level walk completes, so labels and fixups
+// declared inside bodies land in the same compiler state as the rest of the
+// program.
+func (c *compiler) emitHoistedSubs() error {
+	for _, name := range c.subOrder {
+		body := c.subs[name]
+		c.inSubBody = true
+		c.subName = name
+		c.depth = nil          // entered via JUMP from arbitrarily many call sites
+		end := c.enterSpan(-1) // synthetic: Sub's own entry JUMPDEST, not an index into body
+		entry := JUMPDEST(name)
+		if err := entry.appendTo(c); err != nil {
+			c.inSubBody = false
+			c.subName = ""
+			return fmt.Errorf("specialops: Sub(%q): %w", name, err)
+		}
+		end(entry)
+		if err := body.appendTo(c); err != nil {
+			c.inSubBody = false
+			c.subName = ""
+			return fmt.Errorf("specialops: Sub(%q): %w", name, err)
+		}
+		c.inSubBody = false
+		c.subName = ""
+	}
+	return nil
+}
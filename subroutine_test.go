@@ -0,0 +1,110 @@
+package specialops
+
+import (
+	"bytes"
+	"testing"
+)
+
+// word returns a 32-byte big-endian calldata word with n in the
+// least-significant byte, e.g. as the sole argument to a contract.
+func word(n byte) []byte {
+	w := make([]byte, 32)
+	w[31] = n
+	return w
+}
+
+// TestSubroutines demonstrates Sub/CallSub/RetSub: multiple call sites into
+// the same subroutine, and a subroutine that recurses into itself.
+func TestSubroutines(t *testing.T) {
+	// double(n) = 2n, called twice in a row from the same program to prove
+	// that each CallSub site gets its own return label.
+	double := Code{
+		Fn(CALLDATALOAD, PUSH0), // <n>
+		CallSub("double"),       // <2n>
+		CallSub("double"),       // <4n>
+		Fn(MSTORE, PUSH0),
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+
+		Sub("double",
+			SetStackDepth(2), // <n, retlabel>
+			SWAP1,            // <retlabel, n>
+			DUP1,             // <retlabel, n, n>
+			ADD,              // <retlabel, 2n>
+			SWAP1,            // <2n, retlabel>
+			RetSub(),
+		),
+	}
+
+	// sumDown(n) = n + sumDown(n-1), sumDown(0) = 0, recursing n deep.
+	sumDown := Code{
+		Fn(CALLDATALOAD, PUSH0), // <n>
+		CallSub("sumDown"),      // <sum(n)>
+		Fn(MSTORE, PUSH0),
+		Fn(RETURN, PUSH0, PUSH(0x20)),
+
+		Sub("sumDown",
+			SetStackDepth(2),                        // <n, retlabel>
+			SWAP1,                                   // <retlabel, n>
+			Fn(ISZERO, DUP1),                        // <retlabel, n, n==0>
+			Fn(JUMPI, PUSHJUMPDEST("sumDown$base")), // <retlabel, n>
+
+			// n != 0: n + sumDown(n-1)
+			PUSH(1), DUP2, SUB, // <retlabel, n, n-1>
+			CallSub("sumDown"), // <retlabel, n, sumDown(n-1)>
+			ADD,                // <retlabel, n+sumDown(n-1)>
+			SWAP1,              // <sum, retlabel>
+			RetSub(),
+
+			// n == 0: return n (i.e. 0)
+			JUMPDEST("sumDown$base"),
+			SWAP1, // <n, retlabel>
+			RetSub(),
+		),
+	}
+
+	tests := []struct {
+		name     string
+		code     Code
+		callData []byte
+		want     []byte
+	}{
+		{name: "double(5) twice = 20", code: double, callData: word(5), want: word(20)},
+		{name: "sumDown(10) = 55", code: sumDown, callData: word(10), want: word(55)},
+		{name: "sumDown(0) = 0", code: sumDown, callData: word(0), want: word(0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.code.Run(tt.callData)
+			if err != nil {
+				t.Fatalf("Code.Run(%#x) error %v", tt.callData, err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Code.Run(%#x) got %#x, want %#x", tt.callData, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRetSubOutsideSub confirms that a stray RetSub() outside any Sub(...)
+// body is a compile error, not a silent miscompile.
+func TestRetSubOutsideSub(t *testing.T) {
+	code := Code{PUSH(1), RetSub()}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with a top-level RetSub() should have failed")
+	}
+}
+
+// TestNestedSubRejected confirms that declaring a Sub inside another Sub's
+// body is rejected rather than silently allowed.
+func TestNestedSubRejected(t *testing.T) {
+	code := Code{
+		Sub("outer",
+			Sub("inner", RetSub()),
+			RetSub(),
+		),
+	}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with a nested Sub(...) should have failed")
+	}
+}
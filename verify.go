@@ -0,0 +1,100 @@
+package specialops
+
+import "fmt"
+
+// verifier is the stack-balance verifier hooked into the compiler via
+// compiler.verify: as Code.Compile() walks the program, every JUMPDEST and
+// every JUMP/JUMPI whose target is statically known records the stack depth
+// it arrives with, and Compile() asks the verifier to check that a label's
+// arrivals agree before emitting bytecode.
+//
+// It's a best-effort CFG walk, not a full EVM stack analysis: the compiler
+// already loses track of depth across anything it can't reason about
+// statically (e.g. CallSub's continuation, whose depth depends on what the
+// subroutine leaves behind), in which case no arrival is recorded at all and
+// a SetStackDepth becomes the sole source of truth for that label. It trusts
+// SetStackDepth as an authoritative override and checks ExpectStackDepth as
+// an assertion against whatever the walk did compute. Anything it can prove
+// inconsistent is reported as an error rather than silently accepted.
+type verifier struct {
+	// arrivals accumulates, per label, every depth a predecessor claims to
+	// arrive with.
+	arrivals map[string][]depthAtLabel
+}
+
+// depthAtLabel is one predecessor's claim about the stack depth on arrival
+// at a label.
+type depthAtLabel struct {
+	depth int
+	// source identifies what supplied depth, for error messages: either a
+	// SetStackDepth/ExpectStackDepth hint or a fall-through/jump edge from
+	// another block.
+	source string
+}
+
+func newVerifier() *verifier {
+	return &verifier{arrivals: map[string][]depthAtLabel{}}
+}
+
+// recordArrival notes that label is reached with depth, from source (used
+// only for error messages).
+func (v *verifier) recordArrival(label string, depth int, source string) {
+	v.arrivals[label] = append(v.arrivals[label], depthAtLabel{depth: depth, source: source})
+}
+
+// authoritative returns, per label, the depth that arrivals are checked
+// against: a SetStackDepth override if one was recorded for that label,
+// otherwise whichever arrival was recorded first.
+func (v *verifier) authoritative() map[string]int {
+	depths := make(map[string]int, len(v.arrivals))
+	for label, arrivals := range v.arrivals {
+		d := arrivals[0].depth
+		for _, a := range arrivals {
+			if a.source == "SetStackDepth" {
+				d = a.depth
+			}
+		}
+		depths[label] = d
+	}
+	return depths
+}
+
+// check requires that every label's arrivals agree, once SetStackDepth
+// overrides (recorded with source "SetStackDepth") have been applied as the
+// authoritative value for that label.
+func (v *verifier) check() error {
+	want := v.authoritative()
+	for label, arrivals := range v.arrivals {
+		for _, a := range arrivals {
+			if a.source == "SetStackDepth" {
+				continue
+			}
+			if a.depth != want[label] {
+				return fmt.Errorf(
+					"specialops: stack depth mismatch at JUMPDEST(%q): %s arrives with depth %d, want %d",
+					label, a.source, a.depth, want[label],
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// StackDepths returns the statically known stack depth at each JUMPDEST in
+// code, for tooling (e.g. an editor plugin or the debugger). It re-runs the
+// same walk Compile() uses to verify code and surfaces the authoritative
+// depth computed for every label.
+func (code Code) StackDepths() (map[string]int, error) {
+	c := newCompiler()
+	c.verify = newVerifier()
+	if err := code.appendTo(c); err != nil {
+		return nil, err
+	}
+	if err := c.emitHoistedSubs(); err != nil {
+		return nil, err
+	}
+	if err := c.verify.check(); err != nil {
+		return nil, err
+	}
+	return c.verify.authoritative(), nil
+}
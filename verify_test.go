@@ -0,0 +1,135 @@
+package specialops
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestHashOrEchoVerifiesCleanly proves that the hashOrEcho fixture from
+// TestRunCompiled — forward and backward jumps, a JUMPI branch, and
+// SetStackDepth/ExpectStackDepth hints — passes the stack-balance verifier,
+// and that StackDepths() reports the depth an author would expect at each
+// label.
+func TestHashOrEchoVerifiesCleanly(t *testing.T) {
+	hashOrEcho := Code{
+		Fn(SUB, CALLDATASIZE, PUSH(1)),
+		Fn(CALLDATACOPY, PUSH0, PUSH(1), DUP1, ExpectStackDepth(1)),
+
+		Fn(SHR, PUSH(248), Fn(CALLDATALOAD, PUSH0)),
+		Fn(JUMPI, PUSHJUMPDEST("hash")),
+
+		JUMPDEST("return"),
+		SetStackDepth(1),
+		Fn(RETURN, PUSH0),
+
+		JUMPDEST("hash"),
+		SetStackDepth(1),
+		Fn(MSTORE, PUSH0, Fn(KECCAK256, PUSH0)),
+		PUSH(0x20),
+		Fn(JUMP, PUSH("return")),
+	}
+
+	if _, err := hashOrEcho.Compile(); err != nil {
+		t.Fatalf("Compile() error %v", err)
+	}
+
+	got, err := hashOrEcho.StackDepths()
+	if err != nil {
+		t.Fatalf("StackDepths() error %v", err)
+	}
+	want := map[string]int{"return": 1, "hash": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StackDepths() = %v, want %v", got, want)
+	}
+}
+
+// TestVerifierCatchesBranchMismatch demonstrates the classic bug a JUMPI
+// introduces: the taken branch reaches "merge" having only consumed the
+// condition and jump target, while the not-taken branch pushes an extra
+// value first, so the two predecessors disagree on depth.
+func TestVerifierCatchesBranchMismatch(t *testing.T) {
+	code := Code{
+		PUSH(1), // <x>
+		Fn(JUMPI, PUSHJUMPDEST("merge"), PUSH(1)), // taken: -> merge with <x>, depth 1
+		PUSH(2),                         // not taken: <x, 2>, depth 2
+		Fn(JUMP, PUSHJUMPDEST("merge")), // -> merge with depth 2
+
+		JUMPDEST("merge"),
+		STOP,
+	}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with mismatched JUMPI branch depths should have failed")
+	}
+}
+
+// TestVerifierCatchesMissingDUP demonstrates a classic off-by-one: the
+// author believes a DUP1 kept the original value around for an
+// ExpectStackDepth a few lines later, but the DUP was never written, so the
+// comparison below consumed the only copy.
+func TestVerifierCatchesMissingDUP(t *testing.T) {
+	code := Code{
+		PUSH(1), // <x>
+		// A DUP1 belongs here, so EQ below leaves <x, x==0>; it was dropped,
+		// so EQ instead consumes the only copy of x.
+		PUSH(0),
+		EQ, // <x==0>
+		ExpectStackDepth(2),
+		Fn(JUMPI, PUSHJUMPDEST("zero")),
+		STOP,
+		JUMPDEST("zero"),
+		STOP,
+	}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with a missing DUP before ExpectStackDepth should have failed")
+	}
+}
+
+// TestVerifierCatchesWrongSwapDepth demonstrates a miscounted SetStackDepth
+// around a SWAP: the author rearranges the stack with SWAP1 and declares a
+// resulting depth as if the SWAP had also pushed a new item, which SWAP
+// never does. The mistake only surfaces once a second path reaches the same
+// label with the depth actually computed.
+func TestVerifierCatchesWrongSwapDepth(t *testing.T) {
+	code := Code{
+		PUSH(1), PUSH(2), // <a, b>
+		Fn(JUMPI, PUSHJUMPDEST("swapped"), PUSH0),
+		Fn(JUMP, PUSHJUMPDEST("merge")), // -> merge with depth 2
+
+		JUMPDEST("swapped"),             // <a, b>
+		SWAP1,                           // <b, a>; still depth 2, SWAP never changes depth
+		SetStackDepth(3),                // wrong: nothing pushed a third item
+		Fn(JUMP, PUSHJUMPDEST("merge")), // -> merge claiming depth 3
+
+		JUMPDEST("merge"),
+		STOP,
+	}
+	if _, err := code.Compile(); err == nil {
+		t.Fatal("Compile() with a mis-declared SetStackDepth after SWAP1 should have failed")
+	}
+}
+
+// TestVerifierIgnoresDiscardedLabelPush proves that PUSHJUMPDEST'ing a
+// label and then discarding it (instead of JUMPing to it) doesn't leave the
+// verifier attributing some later, unrelated JUMP to that stale label. If
+// it did, this program would be wrongly rejected: the computed jump via
+// Inverted(DUP1) really targets "B", never "A", and "A" is only reachable
+// by falling through its own JUMPDEST with the depth SetStackDepth(0)
+// below declares.
+func TestVerifierIgnoresDiscardedLabelPush(t *testing.T) {
+	code := Code{
+		PUSHJUMPDEST("B"), SetStackDepth(1),
+		PUSHJUMPDEST("A"), POP, // discarded; must not haunt the JUMP below
+		Inverted(DUP1), JUMP, // really jumps to B, not A
+
+		JUMPDEST("A"),
+		SetStackDepth(0),
+		STOP,
+
+		JUMPDEST("B"),
+		SetStackDepth(1),
+		STOP,
+	}
+	if _, err := code.Compile(); err != nil {
+		t.Fatalf("Compile() of a program that discards a pushed label before an unrelated JUMP: %v", err)
+	}
+}